@@ -0,0 +1,489 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package zero_copy
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ABIWordSize is the width, in bytes, of a single slot in Solidity's ABI
+// head/tail encoding. Every static value occupies exactly one word;
+// dynamic values are referenced from the head by a word-sized byte offset.
+const ABIWordSize = 32
+
+// ABIType identifies the Solidity type of a single value passed to
+// WriteABITuple/NextABITuple. This covers the subset that cross-chain
+// manager code needs to round-trip a MakeTxParamWithSender-style payload
+// against an EVM contract; anything else should still go through
+// go-ethereum/accounts/abi.
+type ABIType int
+
+const (
+	ABITypeAddress ABIType = iota
+	ABITypeUint256
+	ABITypeBool
+	ABITypeBytes
+	ABITypeString
+	// ABITypeAddressArray, ABITypeUint256Array and ABITypeBoolArray cover
+	// Solidity's dynamic `T[]` for the static element types this package
+	// already round-trips; each is encoded as a 32-byte length followed
+	// by that many inline elements, with no further head/tail nesting.
+	ABITypeAddressArray
+	ABITypeUint256Array
+	ABITypeBoolArray
+)
+
+func (t ABIType) isDynamic() bool {
+	switch t {
+	case ABITypeBytes, ABITypeString, ABITypeAddressArray, ABITypeUint256Array, ABITypeBoolArray:
+		return true
+	default:
+		return false
+	}
+}
+
+// ErrABIType is returned when a value passed to WriteABITuple doesn't
+// match the ABIType it's declared against.
+var ErrABIType = errors.New("zero_copy: value does not match ABIType")
+
+// ErrABITuple is returned by NextABITuple when the source is too short or
+// a dynamic value's head offset points outside the tuple.
+var ErrABITuple = errors.New("zero_copy: malformed ABI tuple")
+
+// WriteABIAddress writes addr as a 32-byte ABI word, left-padded with
+// zeros; Solidity right-aligns an `address` within its word.
+func (z *ZeroCopySink) WriteABIAddress(addr Address) {
+	buf := z.NextBytes(ABIWordSize)
+	for i := range buf {
+		buf[i] = 0
+	}
+	copy(buf[ABIWordSize-AddrLen:], addr[:])
+}
+
+// WriteABIUint256 writes v as a big-endian 32-byte ABI word.
+func (z *ZeroCopySink) WriteABIUint256(v Uint256) {
+	z.WriteBytes(v[:])
+}
+
+// WriteABIBool writes b as a 32-byte ABI word with the low byte set to 0
+// or 1.
+func (z *ZeroCopySink) WriteABIBool(b bool) {
+	buf := z.NextBytes(ABIWordSize)
+	for i := range buf {
+		buf[i] = 0
+	}
+	if b {
+		buf[ABIWordSize-1] = 1
+	}
+}
+
+// WriteABIBytes writes data as a dynamic ABI `bytes` value: a 32-byte
+// big-endian length followed by data, right-padded with zeros to a
+// multiple of ABIWordSize. Used on its own this writes the value inline;
+// WriteABITuple uses it to fill in the tail of a tuple.
+func (z *ZeroCopySink) WriteABIBytes(data []byte) {
+	var lenBuf [ABIWordSize]byte
+	binary.BigEndian.PutUint64(lenBuf[ABIWordSize-8:], uint64(len(data)))
+	z.WriteBytes(lenBuf[:])
+
+	z.WriteBytes(data)
+	if pad := abiPadding(len(data)); pad > 0 {
+		buf := z.NextBytes(uint64(pad))
+		for i := range buf {
+			buf[i] = 0
+		}
+	}
+}
+
+// WriteABIString writes s as a dynamic ABI `string` value; identical on
+// the wire to WriteABIBytes.
+func (z *ZeroCopySink) WriteABIString(s string) {
+	z.WriteABIBytes([]byte(s))
+}
+
+// WriteABIAddressArray writes addrs as a dynamic ABI `address[]` value: a
+// 32-byte big-endian length followed by each address inline, one word
+// apiece.
+func (z *ZeroCopySink) WriteABIAddressArray(addrs []Address) {
+	var lenBuf [ABIWordSize]byte
+	binary.BigEndian.PutUint64(lenBuf[ABIWordSize-8:], uint64(len(addrs)))
+	z.WriteBytes(lenBuf[:])
+	for _, a := range addrs {
+		z.WriteABIAddress(a)
+	}
+}
+
+// WriteABIUint256Array writes a dynamic ABI `uint256[]` value.
+func (z *ZeroCopySink) WriteABIUint256Array(vs []Uint256) {
+	var lenBuf [ABIWordSize]byte
+	binary.BigEndian.PutUint64(lenBuf[ABIWordSize-8:], uint64(len(vs)))
+	z.WriteBytes(lenBuf[:])
+	for _, v := range vs {
+		z.WriteABIUint256(v)
+	}
+}
+
+// WriteABIBoolArray writes a dynamic ABI `bool[]` value.
+func (z *ZeroCopySink) WriteABIBoolArray(bs []bool) {
+	var lenBuf [ABIWordSize]byte
+	binary.BigEndian.PutUint64(lenBuf[ABIWordSize-8:], uint64(len(bs)))
+	z.WriteBytes(lenBuf[:])
+	for _, b := range bs {
+		z.WriteABIBool(b)
+	}
+}
+
+func abiPadding(n int) int {
+	if r := n % ABIWordSize; r != 0 {
+		return ABIWordSize - r
+	}
+	return 0
+}
+
+func abiUint256FromOffset(off uint64) (u Uint256) {
+	binary.BigEndian.PutUint64(u[Uint256Size-8:], off)
+	return
+}
+
+// WriteABITuple writes values according to types using Solidity's
+// head/tail layout: one 32-byte slot per value in the head (the value
+// itself for static types, a byte offset into the tail for dynamic
+// types), followed by the dynamic values' data in the tail, in order.
+//
+// This is the counterpart to an EVM contract's abi.Arguments.Pack, and
+// lets cross-chain manager code build outgoing tx params without
+// depending on go-ethereum/accounts/abi.
+func (z *ZeroCopySink) WriteABITuple(types []ABIType, values ...interface{}) error {
+	if len(types) != len(values) {
+		return ErrABIType
+	}
+
+	head := NewZeroCopySink(make([]byte, 0, len(types)*ABIWordSize))
+	tail := NewZeroCopySink(nil)
+	headLen := uint64(len(types)) * ABIWordSize
+
+	for i, t := range types {
+		if !t.isDynamic() {
+			if err := writeABIStatic(head, t, values[i]); err != nil {
+				return err
+			}
+			continue
+		}
+
+		head.WriteABIUint256(abiUint256FromOffset(headLen + tail.Size()))
+		if err := writeABIDynamic(tail, t, values[i]); err != nil {
+			return err
+		}
+	}
+
+	z.WriteBytes(head.Bytes())
+	z.WriteBytes(tail.Bytes())
+	return nil
+}
+
+func writeABIStatic(sink *ZeroCopySink, t ABIType, v interface{}) error {
+	switch t {
+	case ABITypeAddress:
+		addr, ok := v.(Address)
+		if !ok {
+			return ErrABIType
+		}
+		sink.WriteABIAddress(addr)
+	case ABITypeUint256:
+		u, ok := v.(Uint256)
+		if !ok {
+			return ErrABIType
+		}
+		sink.WriteABIUint256(u)
+	case ABITypeBool:
+		b, ok := v.(bool)
+		if !ok {
+			return ErrABIType
+		}
+		sink.WriteABIBool(b)
+	default:
+		return ErrABIType
+	}
+	return nil
+}
+
+func writeABIDynamic(sink *ZeroCopySink, t ABIType, v interface{}) error {
+	switch t {
+	case ABITypeBytes:
+		b, ok := v.([]byte)
+		if !ok {
+			return ErrABIType
+		}
+		sink.WriteABIBytes(b)
+	case ABITypeString:
+		s, ok := v.(string)
+		if !ok {
+			return ErrABIType
+		}
+		sink.WriteABIString(s)
+	case ABITypeAddressArray:
+		addrs, ok := v.([]Address)
+		if !ok {
+			return ErrABIType
+		}
+		sink.WriteABIAddressArray(addrs)
+	case ABITypeUint256Array:
+		vs, ok := v.([]Uint256)
+		if !ok {
+			return ErrABIType
+		}
+		sink.WriteABIUint256Array(vs)
+	case ABITypeBoolArray:
+		bs, ok := v.([]bool)
+		if !ok {
+			return ErrABIType
+		}
+		sink.WriteABIBoolArray(bs)
+	default:
+		return ErrABIType
+	}
+	return nil
+}
+
+// NextABIAddress reads a 32-byte ABI word and returns its low 20 bytes as
+// an Address.
+func (z *ZeroCopySource) NextABIAddress() (data Address, eof bool) {
+	buf, eof := z.NextBytes(ABIWordSize)
+	if eof {
+		return
+	}
+	copy(data[:], buf[ABIWordSize-AddrLen:])
+	return
+}
+
+// NextABIUint256 reads a 32-byte big-endian ABI word.
+func (z *ZeroCopySource) NextABIUint256() (data Uint256, eof bool) {
+	buf, eof := z.NextBytes(ABIWordSize)
+	if eof {
+		return
+	}
+	copy(data[:], buf)
+	return
+}
+
+// NextABIBool reads a 32-byte ABI word and reports whether its low byte
+// is non-zero.
+func (z *ZeroCopySource) NextABIBool() (data bool, eof bool) {
+	buf, eof := z.NextBytes(ABIWordSize)
+	if eof {
+		return
+	}
+	data = buf[ABIWordSize-1] != 0
+	return
+}
+
+// NextABIBytes reads a dynamic ABI `bytes` value at the source's current
+// offset: a 32-byte length followed by zero-padded data. When reading a
+// tuple field, seek to its tail position (see NextABITuple) before
+// calling this directly.
+func (z *ZeroCopySource) NextABIBytes() (data []byte, eof bool) {
+	lenBuf, eof := z.NextBytes(ABIWordSize)
+	if eof {
+		return
+	}
+	n := binary.BigEndian.Uint64(lenBuf[ABIWordSize-8:])
+
+	data, eof = z.NextBytes(n)
+	if eof {
+		return
+	}
+	if pad := abiPadding(int(n)); pad > 0 {
+		eof = z.Skip(uint64(pad))
+	}
+	return
+}
+
+// NextABIString reads a dynamic ABI `string` value.
+func (z *ZeroCopySource) NextABIString() (data string, eof bool) {
+	var val []byte
+	val, eof = z.NextABIBytes()
+	data = string(val)
+	return
+}
+
+// nextABIArrayLen reads a 32-byte array length, rejecting a count that
+// couldn't possibly fit in what's left of the source so a bogus length
+// can't drive a runaway allocation below.
+func (z *ZeroCopySource) nextABIArrayLen() (n uint64, eof bool) {
+	lenBuf, eof := z.NextBytes(ABIWordSize)
+	if eof {
+		return
+	}
+	n = binary.BigEndian.Uint64(lenBuf[ABIWordSize-8:])
+	if n > z.Len()/ABIWordSize {
+		return 0, true
+	}
+	return n, false
+}
+
+// NextABIAddressArray reads a dynamic ABI `address[]` value.
+func (z *ZeroCopySource) NextABIAddressArray() (data []Address, eof bool) {
+	n, eof := z.nextABIArrayLen()
+	if eof {
+		return
+	}
+	data = make([]Address, n)
+	for i := range data {
+		data[i], eof = z.NextABIAddress()
+		if eof {
+			return nil, true
+		}
+	}
+	return
+}
+
+// NextABIUint256Array reads a dynamic ABI `uint256[]` value.
+func (z *ZeroCopySource) NextABIUint256Array() (data []Uint256, eof bool) {
+	n, eof := z.nextABIArrayLen()
+	if eof {
+		return
+	}
+	data = make([]Uint256, n)
+	for i := range data {
+		data[i], eof = z.NextABIUint256()
+		if eof {
+			return nil, true
+		}
+	}
+	return
+}
+
+// NextABIBoolArray reads a dynamic ABI `bool[]` value.
+func (z *ZeroCopySource) NextABIBoolArray() (data []bool, eof bool) {
+	n, eof := z.nextABIArrayLen()
+	if eof {
+		return
+	}
+	data = make([]bool, n)
+	for i := range data {
+		data[i], eof = z.NextABIBool()
+		if eof {
+			return nil, true
+		}
+	}
+	return
+}
+
+func (z *ZeroCopySource) nextABIOffset() (off uint64, eof bool) {
+	u, eof := z.NextABIUint256()
+	if eof {
+		return
+	}
+	off = binary.BigEndian.Uint64(u[Uint256Size-8:])
+	return
+}
+
+func readABIStatic(z *ZeroCopySource, t ABIType) (interface{}, bool) {
+	switch t {
+	case ABITypeAddress:
+		v, eof := z.NextABIAddress()
+		return v, eof
+	case ABITypeUint256:
+		v, eof := z.NextABIUint256()
+		return v, eof
+	case ABITypeBool:
+		v, eof := z.NextABIBool()
+		return v, eof
+	default:
+		return nil, true
+	}
+}
+
+func readABIDynamic(z *ZeroCopySource, t ABIType) (interface{}, bool) {
+	switch t {
+	case ABITypeBytes:
+		v, eof := z.NextABIBytes()
+		return v, eof
+	case ABITypeString:
+		v, eof := z.NextABIString()
+		return v, eof
+	case ABITypeAddressArray:
+		v, eof := z.NextABIAddressArray()
+		return v, eof
+	case ABITypeUint256Array:
+		v, eof := z.NextABIUint256Array()
+		return v, eof
+	case ABITypeBoolArray:
+		v, eof := z.NextABIBoolArray()
+		return v, eof
+	default:
+		return nil, true
+	}
+}
+
+// NextABITuple reads a head/tail-encoded tuple matching types, the
+// inverse of WriteABITuple. Dynamic values are read from the tail via the
+// offset stored in the head; on return the source's offset sits
+// immediately after the head, not after the tail, matching how an EVM
+// contract itself only ever hands over the head-relative view.
+//
+// It returns ErrABITuple if the source runs out before the head is fully
+// read, or if a dynamic value's head offset points outside the tuple.
+func (z *ZeroCopySource) NextABITuple(types []ABIType) (values []interface{}, err error) {
+	base := z.Pos()
+	values = make([]interface{}, len(types))
+
+	for i, t := range types {
+		if t.isDynamic() {
+			off, eof := z.nextABIOffset()
+			if eof {
+				return nil, ErrABITuple
+			}
+
+			tailOff, overflow := SafeAdd(base, off)
+			if overflow || tailOff > z.Size() {
+				return nil, ErrABITuple
+			}
+
+			tail := NewZeroCopySource(z.Bytes())
+			tail.off = tailOff
+
+			v, eof := readABIDynamic(tail, t)
+			if eof {
+				return nil, ErrABITuple
+			}
+			values[i] = v
+			continue
+		}
+
+		v, eof := readABIStatic(z, t)
+		if eof {
+			return nil, ErrABITuple
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// ABIShim turns a decoded ABI `bytes` field into a *ZeroCopySource so its
+// payload can be unpacked with this module's native WriteUint*/NextUint*
+// primitives instead of further ABI encoding. This is the shim pattern:
+// an EVM contract declares an argument as `bytes` so Solidity doesn't
+// need to understand its internal structure, while the Go side decodes
+// it natively with a single NextABITuple + ABIShim, replacing the
+// abi.Arguments.Unpack plus manual Copy dance bridge code used before.
+func ABIShim(raw []byte) *ZeroCopySource {
+	return NewZeroCopySource(raw)
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package zero_copy
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestABIStaticValues(t *testing.T) {
+	addr := Address{1, 2, 3, 4, 5}
+	sink := NewZeroCopySink(nil)
+	sink.WriteABIAddress(addr)
+	sink.WriteABIUint256(Uint256{9, 9})
+	sink.WriteABIBool(true)
+
+	src := NewZeroCopySource(sink.Bytes())
+	gotAddr, eof := src.NextABIAddress()
+	if eof || gotAddr != addr {
+		t.Fatalf("NextABIAddress = %v, eof=%v", gotAddr, eof)
+	}
+	gotU, eof := src.NextABIUint256()
+	if eof || gotU != (Uint256{9, 9}) {
+		t.Fatalf("NextABIUint256 = %v, eof=%v", gotU, eof)
+	}
+	gotB, eof := src.NextABIBool()
+	if eof || !gotB {
+		t.Fatalf("NextABIBool = %v, eof=%v", gotB, eof)
+	}
+}
+
+func TestABIBytesAndStringRoundTrip(t *testing.T) {
+	sink := NewZeroCopySink(nil)
+	sink.WriteABIBytes([]byte("hello"))
+	sink.WriteABIString("world")
+
+	src := NewZeroCopySource(sink.Bytes())
+	b, eof := src.NextABIBytes()
+	if eof || string(b) != "hello" {
+		t.Fatalf("NextABIBytes = %q, eof=%v", b, eof)
+	}
+	s, eof := src.NextABIString()
+	if eof || s != "world" {
+		t.Fatalf("NextABIString = %q, eof=%v", s, eof)
+	}
+}
+
+func TestABITupleRoundTrip(t *testing.T) {
+	types := []ABIType{
+		ABITypeUint256,
+		ABITypeAddress,
+		ABITypeBool,
+		ABITypeBytes,
+		ABITypeString,
+		ABITypeAddressArray,
+		ABITypeUint256Array,
+		ABITypeBoolArray,
+	}
+	addrs := []Address{{1}, {2}, {3}}
+	u256s := []Uint256{{4}, {5}}
+	bools := []bool{true, false, true}
+	values := []interface{}{
+		Uint256{7},
+		Address{9},
+		true,
+		[]byte("payload"),
+		"a string value",
+		addrs,
+		u256s,
+		bools,
+	}
+
+	sink := NewZeroCopySink(nil)
+	if err := sink.WriteABITuple(types, values...); err != nil {
+		t.Fatalf("WriteABITuple: %v", err)
+	}
+
+	src := NewZeroCopySource(sink.Bytes())
+	got, err := src.NextABITuple(types)
+	if err != nil {
+		t.Fatalf("NextABITuple: %v", err)
+	}
+
+	for i, want := range values {
+		if !reflect.DeepEqual(got[i], want) {
+			t.Fatalf("field %d = %#v, want %#v", i, got[i], want)
+		}
+	}
+}
+
+func TestABITupleTypeMismatch(t *testing.T) {
+	sink := NewZeroCopySink(nil)
+	err := sink.WriteABITuple([]ABIType{ABITypeUint256}, "not a Uint256")
+	if err != ErrABIType {
+		t.Fatalf("WriteABITuple type mismatch = %v, want ErrABIType", err)
+	}
+}
+
+func TestABITupleArgCountMismatch(t *testing.T) {
+	sink := NewZeroCopySink(nil)
+	err := sink.WriteABITuple([]ABIType{ABITypeUint256, ABITypeBool}, Uint256{})
+	if err != ErrABIType {
+		t.Fatalf("WriteABITuple arg count mismatch = %v, want ErrABIType", err)
+	}
+}
+
+func TestABITupleTruncated(t *testing.T) {
+	types := []ABIType{ABITypeUint256, ABITypeBytes}
+	sink := NewZeroCopySink(nil)
+	if err := sink.WriteABITuple(types, Uint256{1}, []byte("payload")); err != nil {
+		t.Fatalf("WriteABITuple: %v", err)
+	}
+
+	// Keep only the head (one word per type), dropping the tail entirely.
+	head := sink.Bytes()[:len(types)*ABIWordSize]
+	src := NewZeroCopySource(head)
+	if _, err := src.NextABITuple(types); err != ErrABITuple {
+		t.Fatalf("NextABITuple(truncated tail) = %v, want ErrABITuple", err)
+	}
+}
+
+func TestABITupleBadOffset(t *testing.T) {
+	types := []ABIType{ABITypeUint256, ABITypeBytes}
+	sink := NewZeroCopySink(nil)
+	if err := sink.WriteABITuple(types, Uint256{1}, []byte("payload")); err != nil {
+		t.Fatalf("WriteABITuple: %v", err)
+	}
+
+	b := append([]byte(nil), sink.Bytes()...)
+	// Corrupt the second field's head offset word to point past the end
+	// of the tuple.
+	for i := ABIWordSize; i < 2*ABIWordSize; i++ {
+		b[i] = 0xFF
+	}
+
+	src := NewZeroCopySource(b)
+	if _, err := src.NextABITuple(types); err != ErrABITuple {
+		t.Fatalf("NextABITuple(bad offset) = %v, want ErrABITuple", err)
+	}
+}
+
+func TestABIShim(t *testing.T) {
+	inner := NewZeroCopySink(nil)
+	inner.WriteUint32(42)
+	inner.WriteVarBytes([]byte("native payload"))
+
+	sink := NewZeroCopySink(nil)
+	if err := sink.WriteABITuple([]ABIType{ABITypeBytes}, inner.Bytes()); err != nil {
+		t.Fatalf("WriteABITuple: %v", err)
+	}
+
+	src := NewZeroCopySource(sink.Bytes())
+	values, err := src.NextABITuple([]ABIType{ABITypeBytes})
+	if err != nil {
+		t.Fatalf("NextABITuple: %v", err)
+	}
+
+	shim := ABIShim(values[0].([]byte))
+	n, eof := shim.NextUint32()
+	if eof || n != 42 {
+		t.Fatalf("shim.NextUint32 = %d, eof=%v", n, eof)
+	}
+	payload, eof := shim.NextVarBytes()
+	if eof || !bytes.Equal(payload, []byte("native payload")) {
+		t.Fatalf("shim.NextVarBytes = %q, eof=%v", payload, eof)
+	}
+}
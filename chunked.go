@@ -0,0 +1,289 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package zero_copy
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sort"
+)
+
+// chunkedMagic tags the footer of a container written by ChunkedSink.
+const chunkedMagic = "ZCCK"
+
+// chunkedFooterSize is the fixed width of {tocOffset uint64, tocCount
+// uint32, magic [4]byte}.
+const chunkedFooterSize = 8 + 4 + 4
+
+// chunkTOCEntrySize is the fixed width of a single ChunkTOCEntry on the
+// wire: {logicalOffset uint64, physicalOffset uint64, compressedLen
+// uint32, uncompressedLen uint32, sha256 [32]byte}.
+const chunkTOCEntrySize = 8 + 8 + 4 + 4 + 32
+
+// ErrBadChunkedContainer is returned when a ChunkedSource can't parse the
+// footer or table of contents of b.
+var ErrBadChunkedContainer = errors.New("zero_copy: malformed chunked container")
+
+// ErrChunkNotFound is returned when a requested chunk index or logical
+// offset isn't covered by the container's table of contents.
+var ErrChunkNotFound = errors.New("zero_copy: no chunk covers the requested offset")
+
+// ErrInvalidChunkSize is returned by NewChunkedSink when chunkSize isn't
+// positive: with a zero size Write would flush empty chunks forever, and
+// with a negative size it would panic slicing its pending buffer.
+var ErrInvalidChunkSize = errors.New("zero_copy: chunk size must be positive")
+
+// ErrChunkVerifyFailed is returned by VerifyChunk when a chunk's bytes
+// don't hash to the SHA256 recorded for it in the TOC.
+var ErrChunkVerifyFailed = errors.New("zero_copy: chunk does not match its TOC commitment")
+
+// ChunkTOCEntry describes a single chunk in a chunked container's table
+// of contents, letting a reader seek straight to it or verify it against
+// a commitment without touching the rest of the container.
+type ChunkTOCEntry struct {
+	LogicalOffset   uint64
+	PhysicalOffset  uint64
+	CompressedLen   uint32
+	UncompressedLen uint32
+	SHA256          [32]byte
+}
+
+// ChunkedSink splits a payload written through it into fixed-size chunks
+// and, on Finish, appends a table-of-contents footer so a ChunkedSource
+// can seek to any chunk without scanning from offset 0 — the same
+// boundary-preserving-plus-index idea eStargz uses for container images,
+// applied here so relayers can fetch or verify a single proof chunk out
+// of a multi-megabyte batch.
+type ChunkedSink struct {
+	sink      *ZeroCopySink
+	chunkSize int
+	pending   []byte
+	logical   uint64
+	toc       []ChunkTOCEntry
+}
+
+// NewChunkedSink returns a ChunkedSink that flushes a chunk to its
+// internal sink every chunkSize bytes written. It returns
+// ErrInvalidChunkSize if chunkSize isn't positive.
+func NewChunkedSink(chunkSize int) (*ChunkedSink, error) {
+	if chunkSize <= 0 {
+		return nil, ErrInvalidChunkSize
+	}
+	return &ChunkedSink{
+		sink:      NewZeroCopySink(nil),
+		chunkSize: chunkSize,
+	}, nil
+}
+
+// Write implements io.Writer, buffering p and transparently flushing full
+// chunks as they fill.
+func (c *ChunkedSink) Write(p []byte) (int, error) {
+	n := len(p)
+	c.pending = append(c.pending, p...)
+	for len(c.pending) >= c.chunkSize {
+		c.flush(c.pending[:c.chunkSize])
+		c.pending = c.pending[c.chunkSize:]
+	}
+	return n, nil
+}
+
+func (c *ChunkedSink) flush(chunk []byte) {
+	physical := c.sink.Size()
+	c.sink.WriteVarBytes(chunk)
+	c.toc = append(c.toc, ChunkTOCEntry{
+		LogicalOffset:   c.logical,
+		PhysicalOffset:  physical,
+		CompressedLen:   uint32(len(chunk)),
+		UncompressedLen: uint32(len(chunk)),
+		SHA256:          sha256.Sum256(chunk),
+	})
+	c.logical += uint64(len(chunk))
+}
+
+// Finish flushes any partial final chunk, writes the TOC and footer, and
+// returns the complete container bytes.
+func (c *ChunkedSink) Finish() []byte {
+	if len(c.pending) > 0 {
+		c.flush(c.pending)
+		c.pending = nil
+	}
+
+	tocOffset := c.sink.Size()
+	for _, e := range c.toc {
+		c.sink.WriteUint64(e.LogicalOffset)
+		c.sink.WriteUint64(e.PhysicalOffset)
+		c.sink.WriteUint32(e.CompressedLen)
+		c.sink.WriteUint32(e.UncompressedLen)
+		c.sink.WriteBytes(e.SHA256[:])
+	}
+
+	c.sink.WriteUint64(tocOffset)
+	c.sink.WriteUint32(uint32(len(c.toc)))
+	c.sink.WriteBytes([]byte(chunkedMagic))
+
+	return c.sink.Bytes()
+}
+
+// ChunkedSource parses a container written by ChunkedSink and allows
+// random access to individual chunks via its table of contents without
+// deserializing the whole container.
+type ChunkedSource struct {
+	b   []byte
+	toc []ChunkTOCEntry
+}
+
+// NewChunkedSource parses the footer and table of contents of b.
+func NewChunkedSource(b []byte) (*ChunkedSource, error) {
+	if len(b) < chunkedFooterSize {
+		return nil, ErrBadChunkedContainer
+	}
+
+	footer := b[len(b)-chunkedFooterSize:]
+	if string(footer[12:16]) != chunkedMagic {
+		return nil, ErrBadChunkedContainer
+	}
+	tocOffset := binary.LittleEndian.Uint64(footer[0:8])
+	tocCount := binary.LittleEndian.Uint32(footer[8:12])
+
+	tocEnd := tocOffset + uint64(tocCount)*chunkTOCEntrySize
+	if tocOffset > uint64(len(b)) || tocEnd > uint64(len(b)-chunkedFooterSize) {
+		return nil, ErrBadChunkedContainer
+	}
+
+	src := NewZeroCopySource(b[tocOffset:])
+	toc := make([]ChunkTOCEntry, tocCount)
+	for i := range toc {
+		e, eof := nextChunkTOCEntry(src)
+		if eof {
+			return nil, ErrBadChunkedContainer
+		}
+		toc[i] = e
+	}
+
+	return &ChunkedSource{b: b, toc: toc}, nil
+}
+
+func nextChunkTOCEntry(src *ZeroCopySource) (e ChunkTOCEntry, eof bool) {
+	e.LogicalOffset, eof = src.NextUint64()
+	if eof {
+		return
+	}
+	e.PhysicalOffset, eof = src.NextUint64()
+	if eof {
+		return
+	}
+	e.CompressedLen, eof = src.NextUint32()
+	if eof {
+		return
+	}
+	e.UncompressedLen, eof = src.NextUint32()
+	if eof {
+		return
+	}
+	shaBuf, eof := src.NextBytes(32)
+	if eof {
+		return
+	}
+	copy(e.SHA256[:], shaBuf)
+	return
+}
+
+// TOC returns the container's table of contents.
+func (c *ChunkedSource) TOC() []ChunkTOCEntry { return c.toc }
+
+// chunkIndex returns the TOC index of the chunk covering logical offset
+// off, or -1 if none does. ChunkedSink.flush appends chunks in strictly
+// increasing LogicalOffset order, so the TOC is already sorted and a
+// binary search finds the covering chunk without scanning the whole
+// index — the point of building an in-memory index in the first place.
+func (c *ChunkedSource) chunkIndex(off uint64) int {
+	i := sort.Search(len(c.toc), func(i int) bool {
+		e := c.toc[i]
+		return e.LogicalOffset+uint64(e.UncompressedLen) > off
+	})
+	if i == len(c.toc) || off < c.toc[i].LogicalOffset {
+		return -1
+	}
+	return i
+}
+
+// ChunkAt returns a ZeroCopySource scoped to the i'th chunk's bytes.
+func (c *ChunkedSource) ChunkAt(i int) (*ZeroCopySource, error) {
+	if i < 0 || i >= len(c.toc) {
+		return nil, ErrChunkNotFound
+	}
+	e := c.toc[i]
+	if e.PhysicalOffset > uint64(len(c.b)) {
+		return nil, ErrBadChunkedContainer
+	}
+	src := NewZeroCopySource(c.b[e.PhysicalOffset:])
+	data, eof := src.NextVarBytes()
+	if eof {
+		return nil, ErrBadChunkedContainer
+	}
+	if uint64(len(data)) != uint64(e.UncompressedLen) {
+		return nil, ErrBadChunkedContainer
+	}
+	return NewZeroCopySource(data), nil
+}
+
+// VerifyChunk reports whether the i'th chunk's bytes hash to the SHA256
+// recorded for it in the TOC, letting a caller check a single proof
+// chunk against its commitment without touching the rest of the
+// container.
+func (c *ChunkedSource) VerifyChunk(i int) error {
+	chunk, err := c.ChunkAt(i)
+	if err != nil {
+		return err
+	}
+	if sha256.Sum256(chunk.Bytes()) != c.toc[i].SHA256 {
+		return ErrChunkVerifyFailed
+	}
+	return nil
+}
+
+// ReadAt returns the n bytes starting at logical offset off, crossing
+// chunk boundaries as needed.
+func (c *ChunkedSource) ReadAt(off, n uint64) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for uint64(len(out)) < n {
+		idx := c.chunkIndex(off)
+		if idx < 0 {
+			return nil, ErrChunkNotFound
+		}
+
+		chunk, err := c.ChunkAt(idx)
+		if err != nil {
+			return nil, err
+		}
+
+		e := c.toc[idx]
+		chunkOff := off - e.LogicalOffset
+		avail := uint64(len(chunk.Bytes())) - chunkOff
+		take := n - uint64(len(out))
+		if take > avail {
+			take = avail
+		}
+
+		out = append(out, chunk.Bytes()[chunkOff:chunkOff+take]...)
+		off += take
+	}
+	return out, nil
+}
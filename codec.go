@@ -0,0 +1,645 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package zero_copy
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// Marshaler lets a type provide its own wire encoding, bypassing the
+// reflection-driven struct codec below. A hand-written Serialize method
+// can be adopted as-is by implementing this.
+type Marshaler interface {
+	MarshalZC(sink *ZeroCopySink) error
+}
+
+// Unmarshaler is the read-side counterpart of Marshaler.
+type Unmarshaler interface {
+	UnmarshalZC(source *ZeroCopySource) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// ErrNotEOF wraps NextXXX's eof result into an error when Unmarshal/Decode
+// runs out of source bytes mid-struct.
+var ErrNotEOF = errors.New("zero_copy: unexpected end of source")
+
+// fieldCodec is the cached, reflection-free plan for one struct field:
+// closures that know how to move a reflect.Value on and off a
+// sink/source, built once per struct type on first use.
+type fieldCodec struct {
+	index  int
+	encode func(sink *ZeroCopySink, v reflect.Value) error
+	decode func(source *ZeroCopySource, v reflect.Value) error
+}
+
+type typePlan struct {
+	fields []fieldCodec
+}
+
+var planCache sync.Map // reflect.Type -> *typePlan
+
+func planFor(t reflect.Type) (*typePlan, error) {
+	if cached, ok := planCache.Load(t); ok {
+		return cached.(*typePlan), nil
+	}
+	if t.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("zero_copy: %s is not a struct", t)
+	}
+
+	plan := &typePlan{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+
+		tag := f.Tag.Get("zc")
+		if tag == "skip" {
+			continue
+		}
+
+		enc, dec, err := codecFor(f.Type, tag)
+		if err != nil {
+			return nil, fmt.Errorf("zero_copy: field %s.%s: %w", t.Name(), f.Name, err)
+		}
+		plan.fields = append(plan.fields, fieldCodec{index: i, encode: enc, decode: dec})
+	}
+
+	actual, _ := planCache.LoadOrStore(t, plan)
+	return actual.(*typePlan), nil
+}
+
+type encodeFn func(sink *ZeroCopySink, v reflect.Value) error
+type decodeFn func(source *ZeroCopySource, v reflect.Value) error
+
+// parseZCTag splits a `zc` struct tag into its primary selector (one of
+// "varuint", "varbytes", "addr", "hash", or "" for none) and its `len=`
+// width option, defaulting to "varuint". Segments are comma-separated and
+// order-independent, so both `zc:"len=uint32"` and `zc:",len=uint32"`
+// (and `zc:"varbytes,len=uint32"`) parse the same way.
+func parseZCTag(tag string) (primary, lenWidth string, err error) {
+	lenWidth = "varuint"
+	for _, seg := range strings.Split(tag, ",") {
+		if seg == "" {
+			continue
+		}
+		if w, ok := strings.CutPrefix(seg, "len="); ok {
+			lenWidth = w
+			continue
+		}
+		if primary != "" {
+			return "", "", fmt.Errorf("zc tag %q: more than one primary selector", tag)
+		}
+		primary = seg
+	}
+	return primary, lenWidth, nil
+}
+
+// codecFor builds the encode/decode closures for a field of type t
+// carrying the given `zc` struct tag.
+func codecFor(t reflect.Type, tag string) (encodeFn, decodeFn, error) {
+	primary, lenWidth, err := parseZCTag(tag)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if t.Kind() == reflect.Ptr {
+		enc, dec, err := codecFor(t.Elem(), tag)
+		if err != nil {
+			return nil, nil, err
+		}
+		return pointerCodec(t.Elem(), enc, dec)
+	}
+
+	if reflect.PtrTo(t).Implements(marshalerType) {
+		return marshalerCodecs(t)
+	}
+
+	switch primary {
+	case "varuint":
+		return varuintCodecs(t)
+	case "varbytes":
+		return varbytesCodecs(t)
+	case "addr":
+		return addrCodecs(t)
+	case "hash":
+		return hashCodecs(t)
+	case "":
+		// No primary selector; fall through to the Kind-based default
+		// below.
+	default:
+		return nil, nil, fmt.Errorf("zc: unknown tag selector %q", primary)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return boolCodecs()
+	case reflect.Uint8:
+		return uint8Codecs()
+	case reflect.Uint16:
+		return uint16Codecs()
+	case reflect.Uint32:
+		return uint32Codecs()
+	case reflect.Uint64:
+		return uint64Codecs()
+	case reflect.Int8:
+		return int8Codecs()
+	case reflect.Int16:
+		return int16Codecs()
+	case reflect.Int32:
+		return int32Codecs()
+	case reflect.Int64:
+		return int64Codecs()
+	case reflect.String:
+		return stringCodecs()
+	case reflect.Struct:
+		return structCodecs(t)
+	case reflect.Array:
+		return arrayCodecs(t)
+	case reflect.Slice:
+		return sliceCodecs(t, lenWidth)
+	default:
+		return nil, nil, fmt.Errorf("unsupported kind %s (add a zc tag or a Marshaler)", t.Kind())
+	}
+}
+
+func marshalerCodecs(t reflect.Type) (encodeFn, decodeFn, error) {
+	if !reflect.PtrTo(t).Implements(unmarshalerType) {
+		return nil, nil, fmt.Errorf("%s implements Marshaler but not Unmarshaler", t)
+	}
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		return v.Addr().Interface().(Marshaler).MarshalZC(sink)
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		return v.Addr().Interface().(Unmarshaler).UnmarshalZC(source)
+	}
+	return enc, dec, nil
+}
+
+func boolCodecs() (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteBool(v.Bool())
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextBool()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetBool(val)
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func uint8Codecs() (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteUint8(uint8(v.Uint()))
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextUint8()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetUint(uint64(val))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func uint16Codecs() (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteUint16(uint16(v.Uint()))
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextUint16()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetUint(uint64(val))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func uint32Codecs() (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteUint32(uint32(v.Uint()))
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextUint32()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetUint(uint64(val))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func uint64Codecs() (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteUint64(v.Uint())
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextUint64()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetUint(val)
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func int8Codecs() (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteUint8(uint8(v.Int()))
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextUint8()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetInt(int64(int8(val)))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func int16Codecs() (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteInt16(int16(v.Int()))
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextInt16()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetInt(int64(val))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func int32Codecs() (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteInt32(int32(v.Int()))
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextInt32()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetInt(int64(val))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func int64Codecs() (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteInt64(v.Int())
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextInt64()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetInt(val)
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func varuintCodecs(t reflect.Type) (encodeFn, decodeFn, error) {
+	if t.Kind() != reflect.Uint64 && t.Kind() != reflect.Uint32 && t.Kind() != reflect.Uint {
+		return nil, nil, fmt.Errorf(`zc:"varuint" needs a uint, uint32 or uint64 field, got %s`, t.Kind())
+	}
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteVarUint(v.Uint())
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextVarUint()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetUint(val)
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func stringCodecs() (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteString(v.String())
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextString()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetString(val)
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func varbytesCodecs(t reflect.Type) (encodeFn, decodeFn, error) {
+	if t.Kind() == reflect.String {
+		return stringCodecs()
+	}
+	if t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.Uint8 {
+		return nil, nil, fmt.Errorf(`zc:"varbytes" needs a []byte or string field, got %s`, t)
+	}
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteVarBytes(v.Bytes())
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextVarBytes()
+		if eof {
+			return ErrNotEOF
+		}
+		v.SetBytes(append([]byte(nil), val...))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func addrCodecs(t reflect.Type) (encodeFn, decodeFn, error) {
+	if t != reflect.TypeOf(Address{}) {
+		return nil, nil, fmt.Errorf(`zc:"addr" needs an Address field, got %s`, t)
+	}
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteAddress(v.Interface().(Address))
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextAddress()
+		if eof {
+			return ErrNotEOF
+		}
+		v.Set(reflect.ValueOf(val))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func hashCodecs(t reflect.Type) (encodeFn, decodeFn, error) {
+	if t != reflect.TypeOf(Uint256{}) {
+		return nil, nil, fmt.Errorf(`zc:"hash" needs a Uint256 field, got %s`, t)
+	}
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		sink.WriteHash(v.Interface().(Uint256))
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		val, eof := source.NextHash()
+		if eof {
+			return ErrNotEOF
+		}
+		v.Set(reflect.ValueOf(val))
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func structCodecs(t reflect.Type) (encodeFn, decodeFn, error) {
+	plan, err := planFor(t)
+	if err != nil {
+		return nil, nil, err
+	}
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		return encodeStruct(sink, v, plan)
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		return decodeStruct(source, v, plan)
+	}
+	return enc, dec, nil
+}
+
+func arrayCodecs(t reflect.Type) (encodeFn, decodeFn, error) {
+	elemEnc, elemDec, err := codecFor(t.Elem(), "")
+	if err != nil {
+		return nil, nil, err
+	}
+	n := t.Len()
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		for i := 0; i < n; i++ {
+			if err := elemEnc(sink, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		for i := 0; i < n; i++ {
+			if err := elemDec(source, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return enc, dec, nil
+}
+
+// sliceCodecs writes a length prefix (width chosen by the `len=` tag
+// option, defaulting to a VarUint) followed by each element in turn.
+func sliceCodecs(t reflect.Type, lenWidth string) (encodeFn, decodeFn, error) {
+	elemEnc, elemDec, err := codecFor(t.Elem(), "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	writeLen, readLen, err := lenWidthCodecs(lenWidth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		n := v.Len()
+		writeLen(sink, uint64(n))
+		for i := 0; i < n; i++ {
+			if err := elemEnc(sink, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		n, eof := readLen(source)
+		if eof {
+			return ErrNotEOF
+		}
+		// Every element occupies at least one byte on the wire, so a
+		// length prefix longer than what's left can't be genuine; reject
+		// it instead of handing an attacker-controlled count straight to
+		// MakeSlice.
+		if n > source.Len() {
+			return ErrNotEOF
+		}
+		slice := reflect.MakeSlice(t, int(n), int(n))
+		for i := 0; i < int(n); i++ {
+			if err := elemDec(source, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	return enc, dec, nil
+}
+
+func lenWidthCodecs(width string) (func(*ZeroCopySink, uint64), func(*ZeroCopySource) (uint64, bool), error) {
+	switch width {
+	case "", "varuint":
+		return func(s *ZeroCopySink, n uint64) { s.WriteVarUint(n) },
+			func(s *ZeroCopySource) (uint64, bool) { return s.NextVarUint() }, nil
+	case "uint32":
+		return func(s *ZeroCopySink, n uint64) { s.WriteUint32(uint32(n)) },
+			func(s *ZeroCopySource) (uint64, bool) { v, eof := s.NextUint32(); return uint64(v), eof }, nil
+	case "uint64":
+		return func(s *ZeroCopySink, n uint64) { s.WriteUint64(n) },
+			func(s *ZeroCopySource) (uint64, bool) { return s.NextUint64() }, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown len width %q", width)
+	}
+}
+
+// pointerCodec wraps an element codec with a leading bool presence flag,
+// so a nil pointer round-trips without writing the pointed-to value.
+func pointerCodec(elem reflect.Type, elemEnc encodeFn, elemDec decodeFn) (encodeFn, decodeFn, error) {
+	enc := func(sink *ZeroCopySink, v reflect.Value) error {
+		if v.IsNil() {
+			sink.WriteBool(false)
+			return nil
+		}
+		sink.WriteBool(true)
+		return elemEnc(sink, v.Elem())
+	}
+	dec := func(source *ZeroCopySource, v reflect.Value) error {
+		present, eof := source.NextBool()
+		if eof {
+			return ErrNotEOF
+		}
+		if !present {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		v.Set(reflect.New(elem))
+		return elemDec(source, v.Elem())
+	}
+	return enc, dec, nil
+}
+
+func encodeStruct(sink *ZeroCopySink, v reflect.Value, plan *typePlan) error {
+	for _, fc := range plan.fields {
+		if err := fc.encode(sink, v.Field(fc.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeStruct(source *ZeroCopySource, v reflect.Value, plan *typePlan) error {
+	for _, fc := range plan.fields {
+		if err := fc.decode(source, v.Field(fc.index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encode walks v (a pointer to, or value of, a struct) via reflection and
+// writes it to sink using the existing WriteUint*/WriteVarBytes/
+// WriteAddress/WriteHash primitives, driven by `zc` struct tags. The
+// per-type field plan is built once per struct type and cached, so
+// repeat calls for the same type skip field-kind reflection.
+func Encode(sink *ZeroCopySink, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return fmt.Errorf("zero_copy: Encode got a nil %s", rv.Type())
+		}
+		rv = rv.Elem()
+	}
+
+	plan, err := planFor(rv.Type())
+	if err != nil {
+		return err
+	}
+	if !rv.CanAddr() {
+		// Marshaler fields are called through a pointer, so a struct
+		// passed by value is encoded from an addressable copy.
+		p := reflect.New(rv.Type())
+		p.Elem().Set(rv)
+		rv = p.Elem()
+	}
+	return encodeStruct(sink, rv, plan)
+}
+
+// Decode is the read-side counterpart of Encode; v must be a non-nil
+// pointer to a struct.
+func Decode(source *ZeroCopySource, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("zero_copy: Decode needs a non-nil pointer, got %T", v)
+	}
+
+	plan, err := planFor(rv.Elem().Type())
+	if err != nil {
+		return err
+	}
+	return decodeStruct(source, rv.Elem(), plan)
+}
+
+// Marshal encodes v (a pointer to, or value of, a struct) to a new byte
+// slice. See Encode for the tagging rules.
+func Marshal(v interface{}) ([]byte, error) {
+	sink := NewZeroCopySink(nil)
+	if err := Encode(sink, v); err != nil {
+		return nil, err
+	}
+	return sink.Bytes(), nil
+}
+
+// Unmarshal decodes b into v, a non-nil pointer to a struct. See Encode
+// for the tagging rules.
+func Unmarshal(b []byte, v interface{}) error {
+	return Decode(NewZeroCopySource(b), v)
+}
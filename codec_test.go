@@ -0,0 +1,204 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package zero_copy
+
+import (
+	"reflect"
+	"testing"
+)
+
+type codecInner struct {
+	Name string
+	Tag  Uint256 `zc:"hash"`
+}
+
+type codecOuter struct {
+	Flag    bool
+	U8      uint8
+	U16     uint16
+	U32     uint32
+	U64     uint64
+	I8      int8
+	I16     int16
+	I32     int32
+	I64     int64
+	Addr    Address `zc:"addr"`
+	Inner   codecInner
+	Ptr     *codecInner
+	NilPtr  *codecInner
+	Arr     [3]uint32
+	VarLen  []uint32
+	U32Len  []uint32 `zc:"len=uint32"`
+	U64Len  []uint32 `zc:",len=uint64"`
+	Skipped int      `zc:"skip"`
+	hidden  int
+}
+
+func TestStructRoundTrip(t *testing.T) {
+	in := codecOuter{
+		Flag:   true,
+		U8:     7,
+		U16:    1000,
+		U32:    100000,
+		U64:    10000000000,
+		I8:     -7,
+		I16:    -1000,
+		I32:    -100000,
+		I64:    -10000000000,
+		Addr:   Address{1, 2, 3},
+		Inner:  codecInner{Name: "inner", Tag: Uint256{9}},
+		Ptr:    &codecInner{Name: "ptr", Tag: Uint256{8}},
+		NilPtr: nil,
+		Arr:    [3]uint32{1, 2, 3},
+		VarLen: []uint32{1, 2, 3},
+		U32Len: []uint32{4, 5, 6},
+		U64Len: []uint32{7, 8, 9},
+	}
+
+	b, err := Marshal(&in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var out codecOuter
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+
+	// Skipped/unexported fields don't round-trip; zero them before
+	// comparing the rest.
+	in.Skipped = 0
+	in.hidden = 0
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("round trip mismatch:\n in: %+v\nout: %+v", in, out)
+	}
+}
+
+func TestStructRoundTrip_LenWidths(t *testing.T) {
+	tests := []struct {
+		name      string
+		tag       string
+		wantBytes int
+	}{
+		{"varuint", "", 13},
+		{"explicit varuint", ",len=varuint", 13},
+		{"bare uint32", "len=uint32", 16},
+		{"comma uint32", ",len=uint32", 16},
+		{"uint64", ",len=uint64", 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			typ := reflect.StructOf([]reflect.StructField{
+				{Name: "Sl", Type: reflect.TypeOf([]uint32(nil)), Tag: reflect.StructTag(`zc:"` + tt.tag + `"`)},
+			})
+			v := reflect.New(typ)
+			v.Elem().Field(0).Set(reflect.ValueOf([]uint32{1, 2, 3}))
+
+			sink := NewZeroCopySink(nil)
+			if err := Encode(sink, v.Interface()); err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			if got := len(sink.Bytes()); got != tt.wantBytes {
+				t.Fatalf("len = %d, want %d", got, tt.wantBytes)
+			}
+
+			out := reflect.New(typ)
+			if err := Decode(NewZeroCopySource(sink.Bytes()), out.Interface()); err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(v.Elem().Field(0).Interface(), out.Elem().Field(0).Interface()) {
+				t.Fatalf("round trip mismatch for %s", tt.name)
+			}
+		})
+	}
+}
+
+func TestUnknownTagSelector(t *testing.T) {
+	type bad struct {
+		F int32 `zc:"bogus"`
+	}
+	if _, err := Marshal(&bad{F: 1}); err == nil {
+		t.Fatal("expected an error for an unknown tag selector, got nil")
+	}
+}
+
+func TestDecodeTruncated(t *testing.T) {
+	type s struct {
+		A uint64
+		B string
+	}
+	b, err := Marshal(&s{A: 1, B: "hello"})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var out s
+	if err := Unmarshal(b[:4], &out); err != ErrNotEOF {
+		t.Fatalf("Unmarshal(truncated) = %v, want ErrNotEOF", err)
+	}
+}
+
+func TestEncodeNilPointer(t *testing.T) {
+	var p *codecInner
+	if err := Encode(NewZeroCopySink(nil), p); err == nil {
+		t.Fatal("expected an error encoding a nil pointer, got nil")
+	}
+}
+
+type codecMarshaler struct {
+	val uint32
+}
+
+func (m *codecMarshaler) MarshalZC(sink *ZeroCopySink) error {
+	sink.WriteUint32(m.val)
+	return nil
+}
+
+func (m *codecMarshaler) UnmarshalZC(source *ZeroCopySource) error {
+	val, eof := source.NextUint32()
+	if eof {
+		return ErrNotEOF
+	}
+	m.val = val
+	return nil
+}
+
+type codecWithMarshaler struct {
+	M codecMarshaler
+}
+
+func TestMarshalerEscapeHatch(t *testing.T) {
+	in := codecWithMarshaler{M: codecMarshaler{val: 42}}
+	b, err := Marshal(&in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	if len(b) != Uint32Size {
+		t.Fatalf("len = %d, want %d (Marshaler should bypass the default field layout)", len(b), Uint32Size)
+	}
+
+	var out codecWithMarshaler
+	if err := Unmarshal(b, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.M.val != 42 {
+		t.Fatalf("M.val = %d, want 42", out.M.val)
+	}
+}
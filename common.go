@@ -12,3 +12,11 @@ const AddrLen = 20
 type Address [AddrLen]byte
 
 type Uint256 [Uint256Size]byte
+
+// SafeAdd returns a+b along with whether that addition overflowed a
+// uint64, so callers like ZeroCopySource.NextBytes can clamp a read
+// instead of wrapping around.
+func SafeAdd(a, b uint64) (sum uint64, overflow bool) {
+	sum = a + b
+	return sum, sum < a
+}
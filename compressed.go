@@ -0,0 +1,216 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package zero_copy
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// CodecID identifies the compression algorithm used for a compressed
+// region written by BeginCompressed/EndCompressed.
+type CodecID uint8
+
+const (
+	// CodecFlate needs no dependency beyond the standard library.
+	CodecFlate CodecID = iota
+	// CodecSnappy wraps github.com/golang/snappy.
+	CodecSnappy
+	// CodecZstd wraps github.com/klauspost/compress/zstd.
+	CodecZstd
+)
+
+// compressedMagic tags a frame written by EndCompressed so NextCompressed
+// can detect a misaligned read.
+const compressedMagic = "ZCCB"
+
+// Codec compresses and decompresses a single region.
+type Codec interface {
+	Compress(dst io.Writer, src []byte) error
+	Decompress(src []byte) ([]byte, error)
+}
+
+var codecRegistry = map[CodecID]Codec{
+	CodecFlate:  flateCodec{},
+	CodecSnappy: snappyCodec{},
+	CodecZstd:   zstdCodec{},
+}
+
+// RegisterCodec makes c available as id to BeginCompressed/NextCompressed.
+// Use it to plug in additional codecs beyond the CodecFlate, CodecSnappy
+// and CodecZstd built in here, typically from an adapter package's
+// init().
+func RegisterCodec(id CodecID, c Codec) {
+	codecRegistry[id] = c
+}
+
+// ErrUnknownCodec is returned when a CodecID has no registered Codec.
+var ErrUnknownCodec = errors.New("zero_copy: unknown compression codec")
+
+// ErrBadCompressedFrame is returned by NextCompressed when the source
+// doesn't hold a well-formed compressed frame at the current offset.
+var ErrBadCompressedFrame = errors.New("zero_copy: malformed compressed frame")
+
+type flateCodec struct{}
+
+func (flateCodec) Compress(dst io.Writer, src []byte) error {
+	w, err := flate.NewWriter(dst, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(src); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func (flateCodec) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type snappyCodec struct{}
+
+func (snappyCodec) Compress(dst io.Writer, src []byte) error {
+	_, err := dst.Write(snappy.Encode(nil, src))
+	return err
+}
+
+func (snappyCodec) Decompress(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+type zstdCodec struct{}
+
+func (zstdCodec) Compress(dst io.Writer, src []byte) error {
+	w, err := zstd.NewWriter(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(src); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (zstdCodec) Decompress(src []byte) ([]byte, error) {
+	r, err := zstd.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// CompressedSink is a plain ZeroCopySink obtained from BeginCompressed
+// that a caller populates as usual; EndCompressed then compresses its
+// bytes and appends the framed result to the parent sink.
+type CompressedSink struct {
+	*ZeroCopySink
+	parent *ZeroCopySink
+	codec  CodecID
+}
+
+// BeginCompressed starts a compressed region written with codec. The
+// caller writes to the returned CompressedSink like any other
+// ZeroCopySink, then calls EndCompressed to fold the compressed bytes
+// back into z. This lets a caller mix compressed sub-sections (block
+// headers, proofs, batched cross-chain messages) with uncompressed
+// regions in the same stream.
+func (z *ZeroCopySink) BeginCompressed(codec CodecID) *CompressedSink {
+	return &CompressedSink{
+		ZeroCopySink: NewZeroCopySink(nil),
+		parent:       z,
+		codec:        codec,
+	}
+}
+
+// EndCompressed compresses c's buffered bytes and appends the frame
+// (magic, codec id, uncompressed length, compressed length, payload) to
+// the parent sink c was created from.
+func (c *CompressedSink) EndCompressed() error {
+	codec, ok := codecRegistry[c.codec]
+	if !ok {
+		return ErrUnknownCodec
+	}
+
+	var buf bytes.Buffer
+	if err := codec.Compress(&buf, c.Bytes()); err != nil {
+		return err
+	}
+
+	z := c.parent
+	z.WriteBytes([]byte(compressedMagic))
+	z.WriteUint8(uint8(c.codec))
+	z.WriteVarUint(c.Size())
+	z.WriteVarUint(uint64(buf.Len()))
+	z.WriteBytes(buf.Bytes())
+	return nil
+}
+
+// NextCompressed reads a compressed region written by
+// BeginCompressed/EndCompressed and returns a ZeroCopySource over its
+// decompressed payload.
+func (z *ZeroCopySource) NextCompressed() (*ZeroCopySource, error) {
+	magic, eof := z.NextBytes(uint64(len(compressedMagic)))
+	if eof || string(magic) != compressedMagic {
+		return nil, ErrBadCompressedFrame
+	}
+
+	codecByte, eof := z.NextUint8()
+	if eof {
+		return nil, ErrBadCompressedFrame
+	}
+
+	uncompressedLen, eof := z.NextVarUint()
+	if eof {
+		return nil, ErrBadCompressedFrame
+	}
+	compressedLen, eof := z.NextVarUint()
+	if eof {
+		return nil, ErrBadCompressedFrame
+	}
+
+	payload, eof := z.NextBytes(compressedLen)
+	if eof {
+		return nil, ErrBadCompressedFrame
+	}
+
+	codec, ok := codecRegistry[CodecID(codecByte)]
+	if !ok {
+		return nil, ErrUnknownCodec
+	}
+
+	out, err := codec.Decompress(payload)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(out)) != uncompressedLen {
+		return nil, ErrBadCompressedFrame
+	}
+
+	return NewZeroCopySource(out), nil
+}
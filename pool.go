@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package zero_copy
+
+import "sync"
+
+// maxPooledSinkCap is the largest buffer capacity ReleaseSink will
+// retain; a sink whose buffer grew past this is dropped instead of
+// pinning that memory in the pool for the life of the process.
+const maxPooledSinkCap = 1 << 20 // 1 MiB
+
+var sinkPool = sync.Pool{
+	New: func() interface{} { return NewZeroCopySink(nil) },
+}
+
+// AcquireSink returns a ZeroCopySink ready for reuse, pulling from a
+// sync.Pool instead of allocating. Intended for hot paths such as
+// per-message consensus/networking serialization, where a fresh
+// NewZeroCopySink(nil) per message otherwise dominates allocation
+// profiles. Pair with ReleaseSink once the sink's bytes have been
+// consumed.
+func AcquireSink() *ZeroCopySink {
+	z := sinkPool.Get().(*ZeroCopySink)
+	z.Reset()
+	return z
+}
+
+// AcquireSinkSized behaves like AcquireSink but guarantees the returned
+// sink's buffer has at least hint bytes of capacity, replacing the
+// pooled buffer up front if it's too small rather than growing mid-write.
+func AcquireSinkSized(hint int) *ZeroCopySink {
+	z := AcquireSink()
+	if cap(z.buf) < hint {
+		z.buf = make([]byte, 0, hint)
+	}
+	return z
+}
+
+// ReleaseSink returns z to the pool for reuse. Buffers larger than
+// maxPooledSinkCap are dropped rather than retained, so a single
+// oversized message can't pin memory indefinitely.
+func ReleaseSink(z *ZeroCopySink) {
+	if cap(z.buf) > maxPooledSinkCap {
+		return
+	}
+	sinkPool.Put(z)
+}
+
+var sourcePool = sync.Pool{
+	New: func() interface{} { return NewZeroCopySource(nil) },
+}
+
+// AcquireSource returns a ZeroCopySource from a sync.Pool. Call Reset to
+// point it at the buffer to read before use.
+func AcquireSource() *ZeroCopySource {
+	return sourcePool.Get().(*ZeroCopySource)
+}
+
+// ReleaseSource returns z to the pool for reuse.
+func ReleaseSource(z *ZeroCopySource) {
+	sourcePool.Put(z)
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package zero_copy
+
+import "testing"
+
+var payload = []byte("the quick brown fox jumps over the lazy dog")
+
+// sinkBytesSink and sourceValuesSink force the escape analysis the way a
+// real caller would (the serialized bytes/decoded value outlive the
+// benchmark body), so the alloc counts below reflect what a message
+// handler actually pays rather than a stack-allocated benchmark artifact.
+var sinkBytesSink []byte
+var sourceValuesSink []byte
+
+// BenchmarkNewSink measures the allocation pattern AcquireSink/ReleaseSink
+// replaces: a fresh NewZeroCopySink(nil) per message.
+func BenchmarkNewSink(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		z := NewZeroCopySink(nil)
+		z.WriteVarBytes(payload)
+		sinkBytesSink = z.Bytes()
+	}
+}
+
+// BenchmarkAcquireSink measures the pooled replacement for BenchmarkNewSink.
+func BenchmarkAcquireSink(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		z := AcquireSink()
+		z.WriteVarBytes(payload)
+		sinkBytesSink = z.Bytes()
+		ReleaseSink(z)
+	}
+}
+
+// BenchmarkNewSource measures the allocation pattern AcquireSource/
+// ReleaseSource replaces: a fresh NewZeroCopySource per message.
+func BenchmarkNewSource(b *testing.B) {
+	z := NewZeroCopySink(nil)
+	z.WriteVarBytes(payload)
+	buf := z.Bytes()
+
+	for i := 0; i < b.N; i++ {
+		s := NewZeroCopySource(buf)
+		sourceValuesSink, _ = s.NextVarBytes()
+	}
+}
+
+// BenchmarkAcquireSource measures the pooled replacement for
+// BenchmarkNewSource.
+func BenchmarkAcquireSource(b *testing.B) {
+	z := NewZeroCopySink(nil)
+	z.WriteVarBytes(payload)
+	buf := z.Bytes()
+
+	for i := 0; i < b.N; i++ {
+		s := AcquireSource()
+		s.Reset(buf)
+		sourceValuesSink, _ = s.NextVarBytes()
+		ReleaseSource(s)
+	}
+}
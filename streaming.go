@@ -0,0 +1,314 @@
+/*
+ * Copyright (C) 2018 The ontology Authors
+ * This file is part of The ontology library.
+ *
+ * The ontology is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The ontology is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public License
+ * along with The ontology.  If not, see <http://www.gnu.org/licenses/>.
+ */
+
+package zero_copy
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ErrShortBuffer is returned by StreamingSource.NextBytes when n exceeds
+// the source's internal buffer size, so the requested span can't be
+// returned as a zero-copy subslice. Callers that need a larger read
+// should use NextBytesFull instead.
+var ErrShortBuffer = io.ErrShortBuffer
+
+// ErrBytesFullTooLarge is returned by NextBytesFull (and NextVarBytes,
+// when it falls back to the allocating path) when the requested length
+// exceeds MaxBytesFullLen. The length comes straight off the wire before
+// any of the corresponding data has arrived, so it must be bounded
+// before it's used to size an allocation.
+var ErrBytesFullTooLarge = errors.New("zero_copy: NextBytesFull length exceeds MaxBytesFullLen")
+
+// MaxBytesFullLen bounds the size NextBytesFull will allocate for a
+// single read. Callers streaming larger payloads than this should read
+// them in their own chunks rather than through NextBytesFull.
+var MaxBytesFullLen = 32 * 1024 * 1024 // 32MiB
+
+// StreamingSink provides ZeroCopySink's WriteBytes, WriteUint8/16/32/64,
+// WriteByte, WriteBool, WriteVarUint and WriteVarBytes, each returning an
+// error, but flushes through a bounded buffer to an underlying io.Writer
+// instead of growing a single in-memory slice. The signed-integer,
+// string, address and hash writers are not provided. Use it for network and disk I/O
+// paths where serializing a large payload shouldn't require holding all
+// of it in memory at once; ZeroCopySink remains the fast path when the
+// whole payload is going to live in memory anyway.
+type StreamingSink struct {
+	w   io.Writer
+	buf []byte
+	n   int
+}
+
+// NewStreamingSink returns a StreamingSink that buffers up to bufSize
+// bytes before flushing to w.
+func NewStreamingSink(w io.Writer, bufSize int) *StreamingSink {
+	return &StreamingSink{w: w, buf: make([]byte, bufSize)}
+}
+
+// Flush writes any buffered bytes to the underlying io.Writer. Callers
+// must call Flush when done writing to guarantee a trailing partial
+// buffer is emitted.
+func (s *StreamingSink) Flush() error {
+	if s.n == 0 {
+		return nil
+	}
+	if _, err := s.w.Write(s.buf[:s.n]); err != nil {
+		return err
+	}
+	s.n = 0
+	return nil
+}
+
+func (s *StreamingSink) WriteBytes(p []byte) error {
+	for len(p) > 0 {
+		if s.n == len(s.buf) {
+			if err := s.Flush(); err != nil {
+				return err
+			}
+		}
+		k := copy(s.buf[s.n:], p)
+		s.n += k
+		p = p[k:]
+	}
+	return nil
+}
+
+func (s *StreamingSink) WriteUint8(data uint8) error {
+	return s.WriteBytes([]byte{data})
+}
+
+func (s *StreamingSink) WriteByte(c byte) error {
+	return s.WriteUint8(c)
+}
+
+func (s *StreamingSink) WriteBool(data bool) error {
+	if data {
+		return s.WriteByte(1)
+	}
+	return s.WriteByte(0)
+}
+
+func (s *StreamingSink) WriteUint16(data uint16) error {
+	var buf [Uint16Size]byte
+	binary.LittleEndian.PutUint16(buf[:], data)
+	return s.WriteBytes(buf[:])
+}
+
+func (s *StreamingSink) WriteUint32(data uint32) error {
+	var buf [Uint32Size]byte
+	binary.LittleEndian.PutUint32(buf[:], data)
+	return s.WriteBytes(buf[:])
+}
+
+func (s *StreamingSink) WriteUint64(data uint64) error {
+	var buf [Uint64Size]byte
+	binary.LittleEndian.PutUint64(buf[:], data)
+	return s.WriteBytes(buf[:])
+}
+
+func (s *StreamingSink) WriteVarUint(data uint64) error {
+	switch {
+	case data < 0xFD:
+		return s.WriteByte(uint8(data))
+	case data <= 0xFFFF:
+		if err := s.WriteByte(0xFD); err != nil {
+			return err
+		}
+		return s.WriteUint16(uint16(data))
+	case data <= 0xFFFFFFFF:
+		if err := s.WriteByte(0xFE); err != nil {
+			return err
+		}
+		return s.WriteUint32(uint32(data))
+	default:
+		if err := s.WriteByte(0xFF); err != nil {
+			return err
+		}
+		return s.WriteUint64(data)
+	}
+}
+
+func (s *StreamingSink) WriteVarBytes(data []byte) error {
+	if err := s.WriteVarUint(uint64(len(data))); err != nil {
+		return err
+	}
+	return s.WriteBytes(data)
+}
+
+// StreamingSource provides ZeroCopySource's NextBytes, NextByte,
+// NextUint8/16/32/64, NextBool, NextVarUint and NextVarBytes, each
+// returning an error, but refills a bounded buffer (compacted on each
+// refill, see fill) from an underlying io.Reader instead of requiring the
+// whole payload up front. The signed-integer, string, address and hash
+// readers are not provided.
+type StreamingSource struct {
+	r   io.Reader
+	buf []byte
+	off int
+	end int
+	err error
+}
+
+// NewStreamingSource returns a StreamingSource that reads from r through
+// a bufSize internal buffer.
+func NewStreamingSource(r io.Reader, bufSize int) *StreamingSource {
+	return &StreamingSource{r: r, buf: make([]byte, bufSize)}
+}
+
+// fill compacts any unread bytes to the start of the buffer and reads
+// more from r.
+func (s *StreamingSource) fill() {
+	if s.off > 0 {
+		copy(s.buf, s.buf[s.off:s.end])
+		s.end -= s.off
+		s.off = 0
+	}
+	if s.err != nil {
+		return
+	}
+	n, err := s.r.Read(s.buf[s.end:])
+	s.end += n
+	if err != nil {
+		s.err = err
+	}
+}
+
+// NextBytes returns a zero-copy subslice of the next n bytes, valid only
+// for n <= the source's buffer size; it returns ErrShortBuffer otherwise,
+// so callers can opt into the allocating NextBytesFull instead.
+func (s *StreamingSource) NextBytes(n int) ([]byte, error) {
+	if n > len(s.buf) {
+		return nil, ErrShortBuffer
+	}
+	for s.end-s.off < n {
+		if s.err != nil {
+			return nil, s.err
+		}
+		s.fill()
+	}
+	data := s.buf[s.off : s.off+n]
+	s.off += n
+	return data, nil
+}
+
+// NextBytesFull reads n bytes into a freshly allocated slice, looping
+// over the underlying io.Reader as needed. Unlike NextBytes it has no
+// upper bound tied to the internal buffer size, at the cost of a copy;
+// it still rejects n > MaxBytesFullLen so a bogus length read off the
+// wire can't trigger a runaway or out-of-range allocation.
+func (s *StreamingSource) NextBytesFull(n int) ([]byte, error) {
+	if n < 0 || n > MaxBytesFullLen {
+		return nil, ErrBytesFullTooLarge
+	}
+	out := make([]byte, n)
+	read := 0
+	for read < n {
+		if s.end == s.off {
+			if s.err != nil {
+				return nil, s.err
+			}
+			s.fill()
+			continue
+		}
+		k := copy(out[read:], s.buf[s.off:s.end])
+		s.off += k
+		read += k
+	}
+	return out, nil
+}
+
+func (s *StreamingSource) NextByte() (byte, error) {
+	b, err := s.NextBytes(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func (s *StreamingSource) NextUint8() (uint8, error) {
+	b, err := s.NextByte()
+	return uint8(b), err
+}
+
+func (s *StreamingSource) NextBool() (bool, error) {
+	b, err := s.NextByte()
+	if err != nil {
+		return false, err
+	}
+	return b != 0, nil
+}
+
+func (s *StreamingSource) NextUint16() (uint16, error) {
+	b, err := s.NextBytes(Uint16Size)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+func (s *StreamingSource) NextUint32() (uint32, error) {
+	b, err := s.NextBytes(Uint32Size)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (s *StreamingSource) NextUint64() (uint64, error) {
+	b, err := s.NextBytes(Uint64Size)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+func (s *StreamingSource) NextVarUint() (uint64, error) {
+	fb, err := s.NextByte()
+	if err != nil {
+		return 0, err
+	}
+
+	switch fb {
+	case 0xFD:
+		v, err := s.NextUint16()
+		return uint64(v), err
+	case 0xFE:
+		v, err := s.NextUint32()
+		return uint64(v), err
+	case 0xFF:
+		return s.NextUint64()
+	default:
+		return uint64(fb), nil
+	}
+}
+
+func (s *StreamingSource) NextVarBytes() ([]byte, error) {
+	n, err := s.NextVarUint()
+	if err != nil {
+		return nil, err
+	}
+	if n <= uint64(len(s.buf)) {
+		return s.NextBytes(int(n))
+	}
+	if n > uint64(MaxBytesFullLen) {
+		return nil, ErrBytesFullTooLarge
+	}
+	return s.NextBytesFull(int(n))
+}
@@ -239,3 +239,11 @@ func (z *ZeroCopySource) NextVarUint() (data uint64, eof bool) {
 
 // NewZeroCopySource NewReader returns a new ZeroCopySource reading from b.
 func NewZeroCopySource(b []byte) *ZeroCopySource { return &ZeroCopySource{b, 0} }
+
+// Reset points z at b and resets its read offset to 0, mirroring
+// ZeroCopySink.Reset, so a source can be reused against a new buffer
+// without allocating a new struct.
+func (z *ZeroCopySource) Reset(b []byte) {
+	z.s = b
+	z.off = 0
+}